@@ -0,0 +1,341 @@
+// Package ingest exposes a /v1/traces-style OTLP/HTTP endpoint that
+// accepts spans from untrusted clients (typically a browser frontend)
+// and replays them through the server's own TracerProvider, so a single
+// trace can span browser -> API without exposing Tempo directly.
+package ingest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// maxBodyBytes bounds how much of an untrusted client's export request we
+// read before giving up, independent of rate limiting.
+const maxBodyBytes = 1 << 20 // 1 MiB
+
+// sensitiveResourceAttrs is stripped from every client-submitted resource
+// before its spans are replayed, so a browser client can't use the
+// ingestion endpoint to leak or spoof server-side infrastructure details.
+var sensitiveResourceAttrs = map[string]bool{
+	"host.name":               true,
+	"host.id":                 true,
+	"os.type":                 true,
+	"os.description":          true,
+	"process.pid":             true,
+	"process.command_line":    true,
+	"process.executable.path": true,
+	"container.id":            true,
+	"k8s.pod.name":            true,
+	"k8s.node.name":           true,
+}
+
+// Options configures Handler.
+type Options struct {
+	// ServiceName is recorded as the service.name attribute of every
+	// ingested span, replacing whatever service.name the client claims,
+	// so one caller can't impersonate another service in the trace
+	// backend. It is a span attribute: the spans' resource is the server
+	// TracerProvider's own, so their resource-level service.name is the
+	// server's.
+	ServiceName string
+
+	// RateLimit and Burst bound how many export requests a single
+	// source IP may make.
+	RateLimit rate.Limit
+	Burst     int
+}
+
+// Handler returns an http.Handler implementing OTLP/HTTP trace ingestion
+// (protobuf or JSON, per Content-Type) for tracerName's tracer. Accepted
+// spans are replayed under the trace ID the client sent them with (see
+// replaySpan), not under the request's own server span, and flow through
+// the same batching TracerProvider used for server-side spans.
+func Handler(tracerName string, opts Options) http.Handler {
+	tracer := otel.Tracer(tracerName)
+	limiters := newPerIPLimiter(opts.RateLimit, opts.Burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !limiters.allow(clientIP(r)) {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "request body too large or unreadable", http.StatusBadRequest)
+			return
+		}
+
+		contentType := r.Header.Get("Content-Type")
+		var req coltracepb.ExportTraceServiceRequest
+		if strings.HasPrefix(contentType, "application/json") {
+			err = protojson.Unmarshal(body, &req)
+		} else {
+			err = proto.Unmarshal(body, &req)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid OTLP export request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		replayResourceSpans(r.Context(), tracer, req.GetResourceSpans(), opts.ServiceName)
+		writeExportResponse(w, contentType)
+	})
+}
+
+func replayResourceSpans(ctx context.Context, tracer trace.Tracer, resourceSpans []*tracepb.ResourceSpans, serviceName string) int {
+	count := 0
+	for _, rs := range resourceSpans {
+		resourceAttrs := sanitizeResourceAttrs(rs.GetResource().GetAttributes(), serviceName)
+
+		var spans []*tracepb.Span
+		for _, ss := range rs.GetScopeSpans() {
+			spans = append(spans, ss.GetSpans()...)
+		}
+		// Replay parents before children wherever possible, so a child's
+		// parentSpanContext lookup finds its parent's already-assigned
+		// SpanContext instead of falling back to a synthetic one.
+		sort.SliceStable(spans, func(i, j int) bool {
+			return spans[i].GetStartTimeUnixNano() < spans[j].GetStartTimeUnixNano()
+		})
+
+		spanCtxByID := make(map[string]trace.SpanContext, len(spans))
+		for _, span := range spans {
+			replaySpan(ctx, tracer, span, resourceAttrs, spanCtxByID)
+			count++
+		}
+	}
+	return count
+}
+
+// sanitizeResourceAttrs strips infra-sensitive attributes and forces
+// service.name to serviceName regardless of what the client sent.
+func sanitizeResourceAttrs(attrs []*commonpb.KeyValue, serviceName string) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs)+1)
+	out = append(out, semconv.ServiceNameKey.String(serviceName))
+
+	for _, kv := range attrs {
+		key := kv.GetKey()
+		if key == string(semconv.ServiceNameKey) || sensitiveResourceAttrs[key] {
+			continue
+		}
+		out = append(out, attribute.String("client.resource."+key, anyValueToString(kv.GetValue())))
+	}
+	return out
+}
+
+// replaySpan starts and ends a real SDK span that continues the client's
+// trace - as a child of another span already replayed in this batch where
+// one is known, or else directly under the client's own trace ID - but is
+// otherwise fully managed - sampled, batched, and exported - by the
+// server's own TracerProvider. The SDK assigns its own span ID, so the
+// original is recorded as a client.span_id attribute and spanCtxByID maps
+// it to that new ID for any children replayed afterward.
+func replaySpan(ctx context.Context, tracer trace.Tracer, span *tracepb.Span, resourceAttrs []attribute.KeyValue, spanCtxByID map[string]trace.SpanContext) {
+	ctx = trace.ContextWithSpanContext(ctx, parentSpanContext(span, spanCtxByID))
+
+	attrs := append(append([]attribute.KeyValue{}, resourceAttrs...), convertAttrs(span.GetAttributes())...)
+	attrs = append(attrs, attribute.String("client.span_id", hex.EncodeToString(span.GetSpanId())))
+
+	newCtx, sdkSpan := tracer.Start(ctx, span.GetName(),
+		trace.WithTimestamp(time.Unix(0, int64(span.GetStartTimeUnixNano()))),
+		trace.WithSpanKind(spanKind(span.GetKind())),
+		trace.WithAttributes(attrs...),
+	)
+	if span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		sdkSpan.SetStatus(codes.Error, span.GetStatus().GetMessage())
+	}
+	sdkSpan.End(trace.WithTimestamp(time.Unix(0, int64(span.GetEndTimeUnixNano()))))
+
+	spanCtxByID[hex.EncodeToString(span.GetSpanId())] = trace.SpanContextFromContext(newCtx)
+}
+
+// parentSpanContext resolves the SpanContext span should be started under.
+// If its declared parent was already replayed in this batch, that parent's
+// newly-assigned SpanContext is used so the backend sees a real parent/child
+// link. Otherwise - a root span, or a parent outside this export - a
+// synthetic remote context keyed off the span's own (trace ID, span ID) is
+// used instead of the raw parentSpanId: an empty or unresolved parent ID
+// makes trace.SpanContext.IsValid false, and the SDK responds by minting an
+// entirely new trace ID, which would split one client-side trace across two
+// trace IDs in the backend.
+func parentSpanContext(span *tracepb.Span, spanCtxByID map[string]trace.SpanContext) trace.SpanContext {
+	if parentID := hex.EncodeToString(span.GetParentSpanId()); parentID != "" {
+		if sc, ok := spanCtxByID[parentID]; ok {
+			return sc
+		}
+	}
+
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	copy(traceID[:], span.GetTraceId())
+	copy(spanID[:], span.GetSpanId())
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func spanKind(k tracepb.Span_SpanKind) trace.SpanKind {
+	switch k {
+	case tracepb.Span_SPAN_KIND_CLIENT:
+		return trace.SpanKindClient
+	case tracepb.Span_SPAN_KIND_SERVER:
+		return trace.SpanKindServer
+	case tracepb.Span_SPAN_KIND_PRODUCER:
+		return trace.SpanKindProducer
+	case tracepb.Span_SPAN_KIND_CONSUMER:
+		return trace.SpanKindConsumer
+	case tracepb.Span_SPAN_KIND_INTERNAL:
+		return trace.SpanKindInternal
+	default:
+		return trace.SpanKindUnspecified
+	}
+}
+
+func convertAttrs(attrs []*commonpb.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		out = append(out, attribute.String(kv.GetKey(), anyValueToString(kv.GetValue())))
+	}
+	return out
+}
+
+func anyValueToString(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprintf("%t", val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprintf("%d", val.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprintf("%g", val.DoubleValue)
+	default:
+		return v.String()
+	}
+}
+
+func writeExportResponse(w http.ResponseWriter, contentType string) {
+	resp := &coltracepb.ExportTraceServiceResponse{}
+	if strings.HasPrefix(contentType, "application/json") {
+		data, err := protojson.Marshal(resp)
+		if err != nil {
+			http.Error(w, "failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+// limiterIdleTTL bounds how long a per-IP entry survives without traffic.
+// perIPLimiter serves an endpoint open to untrusted public clients, so byIP
+// must not grow without bound as new source IPs show up.
+const limiterIdleTTL = 10 * time.Minute
+
+// limiterSweepEvery amortizes eviction: a full scan of byIP runs every Nth
+// call instead of on every request.
+const limiterSweepEvery = 1024
+
+// perIPLimiter hands out a token-bucket rate.Limiter per source IP,
+// creating one lazily on first use and evicting it after limiterIdleTTL of
+// inactivity.
+type perIPLimiter struct {
+	mu    sync.Mutex
+	byIP  map[string]*limiterEntry
+	rate  rate.Limit
+	burst int
+	calls int
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newPerIPLimiter(r rate.Limit, burst int) *perIPLimiter {
+	return &perIPLimiter{byIP: make(map[string]*limiterEntry), rate: r, burst: burst}
+}
+
+func (l *perIPLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := l.byIP[ip]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.byIP[ip] = entry
+	}
+	entry.lastSeen = now
+
+	l.calls++
+	if l.calls >= limiterSweepEvery {
+		l.calls = 0
+		l.evictIdle(now)
+	}
+
+	return entry.limiter.Allow()
+}
+
+// evictIdle removes entries idle for longer than limiterIdleTTL. Callers
+// must hold l.mu.
+func (l *perIPLimiter) evictIdle(now time.Time) {
+	for ip, entry := range l.byIP {
+		if now.Sub(entry.lastSeen) > limiterIdleTTL {
+			delete(l.byIP, ip)
+		}
+	}
+}
+
+// clientIP keys the rate limiter off the TCP peer address rather than any
+// client-supplied header (X-Forwarded-For et al.), which an untrusted
+// caller could forge to dodge the limit entirely.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus series emitted by WithPromMetrics. Series
+// names and labels follow the stable OTel HTTP semantic conventions
+// (http.server.request.duration et al., translated to Prometheus'
+// underscore naming).
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	activeRequests  *prometheus.GaugeVec
+	requestSize     *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+
+	legacy         bool
+	legacyReqTotal *prometheus.CounterVec
+	legacyReqDur   *prometheus.HistogramVec
+
+	// serverAddress labels the server_address series dimension. It comes
+	// from the server's own configured listen address, never from a
+	// request's Host header - that's client-supplied and, on the public
+	// ingestion path, a cardinality-bomb vector.
+	serverAddress string
+}
+
+// NewMetrics registers the semconv HTTP server metrics against reg, labelling
+// server_address with serverAddress (the app's own configured listen
+// address). When legacy is true (the --legacy-metrics flag), it also
+// registers the original http_requests_total / http_request_duration_seconds
+// series under their old names and labels so existing dashboards and alerts
+// keep working for one release.
+func NewMetrics(reg prometheus.Registerer, legacy bool, serverAddress string) *Metrics {
+	m := &Metrics{
+		serverAddress: serverAddress,
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_request_duration_seconds",
+			Help:    "Duration of HTTP server requests, per the OTel HTTP semantic conventions.",
+			Buckets: prometheus.ExponentialBucketsRange(0.001, 30, 20),
+		}, []string{"http_request_method", "http_route", "http_response_status_code", "network_protocol_name", "server_address"}),
+		activeRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_server_active_requests",
+			Help: "Number of HTTP requests currently being served.",
+		}, []string{"http_request_method", "http_route"}),
+		requestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_request_body_size_bytes",
+			Help:    "Size of HTTP request bodies.",
+			Buckets: prometheus.ExponentialBucketsRange(64, 10*1024*1024, 16),
+		}, []string{"http_request_method", "http_route"}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_server_response_body_size_bytes",
+			Help:    "Size of HTTP response bodies.",
+			Buckets: prometheus.ExponentialBucketsRange(64, 10*1024*1024, 16),
+		}, []string{"http_request_method", "http_route"}),
+		legacy: legacy,
+	}
+	reg.MustRegister(m.requestDuration, m.activeRequests, m.requestSize, m.responseSize)
+
+	if legacy {
+		m.legacyReqTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total HTTP requests. Deprecated, use http_server_request_duration_seconds_count.",
+		}, []string{"method", "route", "status"})
+		m.legacyReqDur = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request duration in seconds. Deprecated, use http_server_request_duration_seconds.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"})
+		reg.MustRegister(m.legacyReqTotal, m.legacyReqDur)
+	}
+	return m
+}
+
+func (m *Metrics) observe(method, route string, r *http.Request, rr *statusRecorder, elapsed time.Duration) {
+	status := strconv.Itoa(rr.status)
+	protocol := "http"
+	seconds := elapsed.Seconds()
+
+	m.requestDuration.WithLabelValues(method, route, status, protocol, m.serverAddress).Observe(seconds)
+	if r.ContentLength > 0 {
+		m.requestSize.WithLabelValues(method, route).Observe(float64(r.ContentLength))
+	}
+	m.responseSize.WithLabelValues(method, route).Observe(float64(rr.written))
+
+	if m.legacy {
+		m.legacyReqDur.WithLabelValues(method, route).Observe(seconds)
+		m.legacyReqTotal.WithLabelValues(method, route, status).Inc()
+	}
+}
@@ -0,0 +1,306 @@
+// Package middleware provides a composable decorator pipeline for
+// http.Handlers (tracing, metrics, access logging, panic recovery, request
+// IDs, timeouts) so callers can opt into exactly the cross-cutting concerns
+// they need instead of a single monolithic wrapper.
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Decorator wraps an http.Handler with additional behavior.
+type Decorator func(http.Handler) http.Handler
+
+// Pipeline is an ordered chain of Decorators. Decorators run outermost
+// first: Pipeline{A, B}.Then(h) calls A, then B, then h.
+type Pipeline []Decorator
+
+// NewPipeline builds a Pipeline from the given decorators, applied in order.
+func NewPipeline(decorators ...Decorator) Pipeline {
+	return Pipeline(decorators)
+}
+
+// Then wraps h with every decorator in the pipeline, outermost first.
+func (p Pipeline) Then(h http.Handler) http.Handler {
+	for i := len(p) - 1; i >= 0; i-- {
+		h = p[i](h)
+	}
+	return h
+}
+
+// ThenFunc is Then for a plain handler func.
+func (p Pipeline) ThenFunc(h http.HandlerFunc) http.Handler {
+	return p.Then(h)
+}
+
+type ctxKey int
+
+const routeCtxKey ctxKey = iota
+
+// RouteFromContext returns the route pattern registered via Mux.Handle for
+// the in-flight request, if any.
+func RouteFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeCtxKey).(string)
+	return route, ok
+}
+
+// Mux is an http.ServeMux that remembers the pattern each handler was
+// registered under, so route-labelled decorators (metrics, tracing) never
+// need the caller to pass the route string by hand.
+type Mux struct {
+	mux      *http.ServeMux
+	pipeline Pipeline
+}
+
+// NewMux returns a Mux that applies pipeline to every handler registered
+// through it.
+func NewMux(pipeline Pipeline) *Mux {
+	return &Mux{mux: http.NewServeMux(), pipeline: pipeline}
+}
+
+// Handle registers h for pattern, wrapped with the Mux's pipeline.
+func (m *Mux) Handle(pattern string, h http.Handler) {
+	wrapped := m.pipeline.Then(h)
+	m.mux.Handle(pattern, withRoute(pattern, wrapped))
+}
+
+// HandleFunc is Handle for a plain handler func.
+func (m *Mux) HandleFunc(pattern string, h http.HandlerFunc) {
+	m.Handle(pattern, h)
+}
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
+
+func withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), routeCtxKey, pattern)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder captures the status code and response body size written
+// by the wrapped handler, defaulting to 200 per net/http convention when
+// WriteHeader is never called explicitly.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// WithTraceContinuation extracts a W3C traceparent (and any tracestate)
+// from the incoming request into its context, so a caller that sent its
+// own span context header - a browser continuing a trace started on page
+// load, for instance - gets stitched into the same trace instead of
+// starting a new one. Place it ahead of WithTracing in the pipeline.
+func WithTraceContinuation() Decorator {
+	propagator := otel.GetTextMapPropagator()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithTracing starts a span per request named "<method> <route>", using the
+// route registered via Mux and falling back to "unmatched" otherwise.
+func WithTracing(tracerName string) Decorator {
+	tracer := otel.Tracer(tracerName)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeOrUnmatched(r.Context())
+			ctx, span := tracer.Start(r.Context(), r.Method+" "+route)
+			defer span.End()
+
+			span.SetAttributes(
+				semconv.HTTPRequestMethod(normalizeMethod(r.Method)),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			)
+
+			rr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rr, r.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rr.status))
+			if rr.status >= 500 {
+				span.RecordError(fmt.Errorf("http %d response", rr.status))
+				span.SetStatus(codes.Error, "server error")
+			} else {
+				span.SetStatus(codes.Ok, "ok")
+			}
+		})
+	}
+}
+
+// WithPromMetrics records the semconv HTTP server metrics on m, labelled
+// with the route registered via Mux rather than a caller-supplied string,
+// so ad-hoc routes can't blow up the label set.
+func WithPromMetrics(m *Metrics) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeOrUnmatched(r.Context())
+			method := normalizeMethod(r.Method)
+			m.activeRequests.WithLabelValues(method, route).Inc()
+			defer m.activeRequests.WithLabelValues(method, route).Dec()
+
+			start := time.Now()
+			rr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rr, r)
+
+			m.observe(method, route, r, rr, time.Since(start))
+		})
+	}
+}
+
+// WithAccessLog logs one structured line per request via logger.
+func WithAccessLog(logger *slog.Logger) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rr, r)
+
+			logger.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rr.status,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"request_id", RequestIDFromContext(r.Context()),
+			)
+		})
+	}
+}
+
+// WithRecover turns a panic in next into a 500 response instead of
+// crashing the server, recording it against the in-flight span. Place it
+// nearest the handler - inside WithTracing, WithPromMetrics and
+// WithAccessLog - so the span it records against is the one actually
+// propagated into next's context, and so the recovered 500 still reaches
+// the metrics/access-log decorators instead of unwinding past them.
+func WithRecover() Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					span := trace.SpanFromContext(r.Context())
+					span.RecordError(asError(rec), trace.WithStackTrace(true))
+					span.SetStatus(codes.Error, "panic recovered")
+					http.Error(w, "internal server error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type requestIDKey int
+
+const requestIDCtxKey requestIDKey = iota
+
+// RequestIDHeader is the response/request header carrying the request ID
+// injected by WithRequestID.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDFromContext returns the request ID injected by WithRequestID,
+// or "" if none is present.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey).(string)
+	return id
+}
+
+// WithRequestID assigns a random request ID to every request that doesn't
+// already carry one, propagating it via context and the response header.
+func WithRequestID() Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			ctx := context.WithValue(r.Context(), requestIDCtxKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithTimeout bounds request handling to d, responding with 503 if it's
+// exceeded. It's a thin wrapper over http.TimeoutHandler.
+func WithTimeout(d time.Duration) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, "request timed out")
+	}
+}
+
+func routeOrUnmatched(ctx context.Context) string {
+	if route, ok := RouteFromContext(ctx); ok {
+		return route
+	}
+	return "unmatched"
+}
+
+// knownHTTPMethods are the methods semconv's http.request.method enumerates.
+var knownHTTPMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// normalizeMethod maps method to itself if it's one of the methods
+// semconv's http.request.method enumerates, or to "_OTHER" otherwise, per
+// the semconv requirement that non-standard methods not be recorded
+// verbatim. Without this, a caller can mint an unbounded number of span
+// attribute / metric label values just by sending an arbitrary method
+// token - notably reachable from the untrusted public /v1/traces endpoint.
+func normalizeMethod(method string) string {
+	if knownHTTPMethods[method] {
+		return method
+	}
+	return "_OTHER"
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func asError(rec interface{}) error {
+	if err, ok := rec.(error); ok {
+		return err
+	}
+	return fmt.Errorf("panic: %v", rec)
+}
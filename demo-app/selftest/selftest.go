@@ -0,0 +1,187 @@
+// Package selftest closes the observability loop: it periodically queries
+// the app's own Prometheus server for the app's own metrics and exposes
+// the result as new gauges, plus a span event when an SLO is breached.
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options configures a Checker.
+type Options struct {
+	// PromURL is the base URL of the Prometheus server to query.
+	PromURL string
+
+	// Interval is how often to run the checks.
+	Interval time.Duration
+
+	// ErrorRateQuery and P99Query are PromQL expressions expected to
+	// return a single instant-vector sample.
+	ErrorRateQuery string
+	P99Query       string
+
+	// ErrorRateThreshold and P99Threshold gate the warning span event:
+	// a query result above the threshold is reported as an SLO breach.
+	ErrorRateThreshold float64
+	P99Threshold       time.Duration
+}
+
+// OptsFromEnv builds Options from PROM_URL and friends, defaulting to the
+// docker-compose Prometheus endpoint and a 5-minute error-rate/latency
+// window matching the demo's own histogram buckets. The default queries
+// target the semconv http_server_request_duration_seconds series middleware
+// emits unconditionally; they only see data if Prometheus is actually
+// scraping this app (the legacy http_requests_total series requires
+// --legacy-metrics and isn't queried by default).
+func OptsFromEnv() Options {
+	return Options{
+		PromURL:            getenv("PROM_URL", "http://prometheus:9090"),
+		Interval:           getenvDuration("SELFCHECK_INTERVAL", 30*time.Second),
+		ErrorRateQuery:     `sum(rate(http_server_request_duration_seconds_count{http_response_status_code=~"5.."}[5m])) / sum(rate(http_server_request_duration_seconds_count[5m]))`,
+		P99Query:           `histogram_quantile(0.99, sum(rate(http_server_request_duration_seconds_bucket[5m])) by (le))`,
+		ErrorRateThreshold: getenvFloat("SELFCHECK_ERROR_RATE_THRESHOLD", 0.05),
+		P99Threshold:       getenvDuration("SELFCHECK_P99_THRESHOLD", 2*time.Second),
+	}
+}
+
+// Checker periodically evaluates Options' queries against Prometheus.
+type Checker struct {
+	opts   Options
+	api    promv1.API
+	tracer trace.Tracer
+
+	errorRate prometheus.Gauge
+	p99       prometheus.Gauge
+}
+
+// New builds a Checker and registers its gauges against reg. It does not
+// start polling; call Run for that.
+func New(reg prometheus.Registerer, opts Options) (*Checker, error) {
+	client, err := api.NewClient(api.Config{Address: opts.PromURL})
+	if err != nil {
+		return nil, fmt.Errorf("selftest: new prometheus client: %w", err)
+	}
+
+	c := &Checker{
+		opts:   opts,
+		api:    promv1.NewAPI(client),
+		tracer: otel.Tracer("demo-app.selftest"),
+		errorRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "selfcheck_error_rate",
+			Help: "5xx error rate over the last 5m, as reported back by Prometheus.",
+		}),
+		p99: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "selfcheck_p99_seconds",
+			Help: "p99 request latency in seconds, as reported back by Prometheus.",
+		}),
+	}
+	reg.MustRegister(c.errorRate, c.p99)
+	return c, nil
+}
+
+// Run polls Prometheus on Options.Interval until ctx is canceled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.checkOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) checkOnce(ctx context.Context) {
+	ctx, span := c.tracer.Start(ctx, "selftest.check")
+	defer span.End()
+
+	if errRate, err := c.queryScalar(ctx, c.opts.ErrorRateQuery); err != nil {
+		span.RecordError(fmt.Errorf("selftest: query error rate: %w", err))
+	} else {
+		c.errorRate.Set(errRate)
+		if errRate > c.opts.ErrorRateThreshold {
+			span.AddEvent("selfcheck.threshold_exceeded", trace.WithAttributes(
+				attribute.String("selfcheck.metric", "error_rate"),
+				attribute.Float64("selfcheck.value", errRate),
+				attribute.Float64("selfcheck.threshold", c.opts.ErrorRateThreshold),
+			))
+		}
+	}
+
+	if p99, err := c.queryScalar(ctx, c.opts.P99Query); err != nil {
+		span.RecordError(fmt.Errorf("selftest: query p99: %w", err))
+	} else {
+		c.p99.Set(p99)
+		if p99 > c.opts.P99Threshold.Seconds() {
+			span.AddEvent("selfcheck.threshold_exceeded", trace.WithAttributes(
+				attribute.String("selfcheck.metric", "p99_seconds"),
+				attribute.Float64("selfcheck.value", p99),
+				attribute.Float64("selfcheck.threshold", c.opts.P99Threshold.Seconds()),
+			))
+		}
+	}
+}
+
+func (c *Checker) queryScalar(ctx context.Context, query string) (float64, error) {
+	result, warnings, err := c.api.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for _, w := range warnings {
+		log.Printf("selftest: prometheus query warning: %s", w)
+	}
+
+	vec, ok := result.(model.Vector)
+	if !ok || len(vec) == 0 {
+		return 0, fmt.Errorf("selftest: query %q returned no samples (metric missing or not yet scraped?)", query)
+	}
+	return float64(vec[0].Value), nil
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func getenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
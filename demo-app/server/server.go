@@ -0,0 +1,179 @@
+// Package server provides a lifecycle manager that layers readiness
+// gating and graceful, draining shutdown over a plain http.Server, so the
+// demo app is safe to roll under a Kubernetes-style load balancer.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrServerShutdown is returned to clients that reach the app handler
+// during the drain window between readiness going false and the listener
+// actually closing.
+var ErrServerShutdown = errors.New("server: shutting down")
+
+// HealthChecker reports whether a dependency (database, cache, upstream
+// API, ...) is healthy. Check should return quickly and respect ctx's
+// deadline.
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// HealthCheckerFunc adapts a function to a HealthChecker.
+type HealthCheckerFunc func(ctx context.Context) error
+
+func (f HealthCheckerFunc) Check(ctx context.Context) error { return f(ctx) }
+
+// Options configures a Server.
+type Options struct {
+	// Addr is the listen address, e.g. ":8080".
+	Addr string
+
+	// Checkers are consulted by /readyz; readiness requires all of them
+	// to pass.
+	Checkers []HealthChecker
+
+	// PreShutdownDelay is how long /readyz reports not-ready before the
+	// HTTP server actually starts shutting down, giving an upstream load
+	// balancer time to stop routing new traffic here.
+	PreShutdownDelay time.Duration
+
+	// GracefulTimeout bounds how long in-flight requests are given to
+	// finish once shutdown starts.
+	GracefulTimeout time.Duration
+
+	// OnShutdown is called, in order, after in-flight requests have
+	// drained (or GracefulTimeout has expired) and before Run returns —
+	// e.g. to flush a tracer provider or a Prometheus pushgateway. Each
+	// hook runs regardless of whether the drain itself timed out, and
+	// gets its own fresh context bounded by GracefulTimeout rather than
+	// sharing whatever's left of the drain's.
+	OnShutdown []func(ctx context.Context) error
+}
+
+// Server wraps an application http.Handler with /healthz, /readyz, and a
+// readiness-gated shutdown sequence.
+type Server struct {
+	http  *http.Server
+	opts  Options
+	ready atomic.Bool
+}
+
+// New wraps appHandler for serving under opts. appHandler is mounted at
+// "/"; /healthz and /readyz are added alongside it.
+func New(appHandler http.Handler, opts Options) *Server {
+	s := &Server{opts: opts}
+	s.ready.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleLiveness)
+	mux.HandleFunc("/readyz", s.handleReadiness)
+	mux.Handle("/", s.gate(appHandler))
+
+	s.http = &http.Server{
+		Addr:              opts.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Run listens until it receives SIGINT/SIGTERM, then drains in-flight
+// requests and runs the configured shutdown hooks before returning.
+// Run returns an error for listener failures other than the expected
+// http.ErrServerClosed, and when draining in-flight requests fails or
+// exceeds GracefulTimeout.
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("server: listening on %s", s.opts.Addr)
+		serveErr <- s.http.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	return s.shutdown()
+}
+
+func (s *Server) shutdown() error {
+	log.Printf("server: shutdown signal received, flipping /readyz and waiting %s", s.opts.PreShutdownDelay)
+	s.ready.Store(false)
+	time.Sleep(s.opts.PreShutdownDelay)
+
+	log.Printf("server: draining in-flight requests (timeout %s)", s.opts.GracefulTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), s.opts.GracefulTimeout)
+	drainErr := s.http.Shutdown(drainCtx)
+	cancelDrain()
+	if drainErr != nil {
+		log.Printf("server: graceful shutdown: %v", drainErr)
+	}
+
+	// Hooks run regardless of drainErr - e.g. the tracer provider must
+	// still flush whatever spans it already has - each with its own fresh
+	// timeout rather than whatever's left of drainCtx.
+	for _, fn := range s.opts.OnShutdown {
+		hookCtx, cancelHook := context.WithTimeout(context.Background(), s.opts.GracefulTimeout)
+		err := fn(hookCtx)
+		cancelHook()
+		if err != nil {
+			log.Printf("server: shutdown hook failed: %v", err)
+		}
+	}
+
+	if drainErr != nil {
+		return fmt.Errorf("server: graceful shutdown: %w", drainErr)
+	}
+	return nil
+}
+
+func (s *Server) gate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, ErrServerShutdown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	for _, checker := range s.opts.Checkers {
+		if err := checker.Check(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,248 @@
+// Package tracing builds an OpenTelemetry TracerProvider from either an
+// explicit TracerOpts value or the standard OTEL_* environment variables,
+// so the demo app can point at Tempo, a local collector, or stdout without
+// recompiling.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// Exporter selects which span exporter New builds.
+type Exporter string
+
+const (
+	ExporterOTLPHTTP Exporter = "otlphttp"
+	ExporterOTLPGRPC Exporter = "otlpgrpc"
+	ExporterStdout   Exporter = "stdout"
+)
+
+// TracerOpts configures the TracerProvider built by New. Defaults are
+// filled in by OptsFromEnv; New itself uses each field as given, except
+// that an empty Exporter selects OTLP/HTTP (a zero SampleRatio samples no
+// root spans).
+type TracerOpts struct {
+	ServiceName string
+	Exporter    Exporter
+	Endpoint    string
+	Insecure    bool
+
+	// TLSConfig is used to dial the exporter endpoint when Insecure is
+	// false. A nil value falls back to the exporter's default TLS config
+	// (system cert pool, no client cert).
+	TLSConfig *tls.Config
+
+	Headers     map[string]string
+	SampleRatio float64
+}
+
+// New builds a batching TracerProvider per opts and installs it as the
+// global provider. Callers must Shutdown the returned provider to flush
+// pending spans before the process exits.
+func New(ctx context.Context, opts TracerOpts) (*sdktrace.TracerProvider, error) {
+	exporter, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: build exporter: %w", err)
+	}
+
+	// No explicit WithSchemaURL here: WithHost/WithProcess/WithContainer
+	// each carry the SDK's own (schemaless) detector output, and pinning a
+	// semconv schema URL on top of them makes resource.New reject the merge
+	// as a schema conflict.
+	//
+	// service.namespace and deployment.environment have no generic default
+	// - they're operator/deployment concerns - so they only appear if set
+	// via OTEL_RESOURCE_ATTRIBUTES (picked up by WithFromEnv). A K8s
+	// deployment should set deployment.environment=prod and
+	// service.namespace=<team> there; service.instance.id, which does have
+	// a sensible default, is generated below.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String(opts.ServiceName),
+			semconv.ServiceInstanceIDKey.String(newInstanceID()),
+		),
+		resource.WithHost(),
+		resource.WithProcess(),
+		resource.WithContainer(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: build resource: %w", err)
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp, nil
+}
+
+// OptsFromEnv builds TracerOpts from OTEL_EXPORTER, OTEL_EXPORTER_OTLP_*
+// and OTEL_TRACES_SAMPLER_ARG, defaulting to an insecure OTLP/HTTP exporter
+// pointed at the docker-compose Tempo endpoint. TLS (and, for mTLS, a
+// client cert/key) is read from the standard OTEL_EXPORTER_OTLP_CERTIFICATE
+// / OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE / OTEL_EXPORTER_OTLP_CLIENT_KEY
+// vars and only applies when OTEL_EXPORTER_OTLP_INSECURE is false.
+func OptsFromEnv(serviceName string) TracerOpts {
+	opts := TracerOpts{
+		ServiceName: serviceName,
+		Exporter:    Exporter(getenv("OTEL_EXPORTER", string(ExporterOTLPHTTP))),
+		Endpoint:    getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "tempo:4318"),
+		Insecure:    getenvBool("OTEL_EXPORTER_OTLP_INSECURE", true),
+		TLSConfig:   tlsConfigFromEnv(),
+		Headers:     parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		SampleRatio: getenvFloat("OTEL_TRACES_SAMPLER_ARG", 1.0),
+	}
+	return opts
+}
+
+// tlsConfigFromEnv builds a *tls.Config from the OTLP exporter's standard
+// TLS env vars, or returns nil (falling back to the exporter's default TLS
+// config) if none are set or a cert/key fails to load.
+func tlsConfigFromEnv() *tls.Config {
+	caPath := os.Getenv("OTEL_EXPORTER_OTLP_CERTIFICATE")
+	certPath := os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE")
+	keyPath := os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_KEY")
+	if caPath == "" && certPath == "" && keyPath == "" {
+		return nil
+	}
+
+	cfg := &tls.Config{}
+	if caPath != "" {
+		pem, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil
+		}
+		cfg.RootCAs = pool
+	}
+	if certPath != "" && keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+func newExporter(ctx context.Context, opts TracerOpts) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case ExporterOTLPGRPC:
+		grpcOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		switch {
+		case opts.Insecure:
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		case opts.TLSConfig != nil:
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(opts.TLSConfig)))
+		}
+		if len(opts.Headers) > 0 {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithHeaders(opts.Headers))
+		}
+		return otlptracegrpc.New(ctx, grpcOpts...)
+
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+
+	case ExporterOTLPHTTP, "":
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+		switch {
+		case opts.Insecure:
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		case opts.TLSConfig != nil:
+			httpOpts = append(httpOpts, otlptracehttp.WithTLSClientConfig(opts.TLSConfig))
+		}
+		if len(opts.Headers) > 0 {
+			httpOpts = append(httpOpts, otlptracehttp.WithHeaders(opts.Headers))
+		}
+		return otlptracehttp.New(ctx, httpOpts...)
+
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", opts.Exporter)
+	}
+}
+
+// newInstanceID returns a random (v4) UUID for service.instance.id,
+// distinguishing this process from other replicas of the same service.
+func newInstanceID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func parseHeaders(raw string) map[string]string {
+	if raw == "" {
+		return nil
+	}
+	headers := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvBool(k string, def bool) bool {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+func getenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}